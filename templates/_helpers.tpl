@@ -0,0 +1,364 @@
+{{/*
+Paths of the dashboard JSON files to deploy, in rendering order.
+Dashboards disabled through their <dashboard>.values.yaml are skipped.
+Fails when two files would render a GrafanaDashboard with the same name.
+Returns a JSON list.
+*/}}
+{{- define "grafana-dashboards.dashboardPaths" -}}
+{{- $paths := list }}
+{{- $names := dict }}
+{{- range $folder := .Values.dashboard_folders }}
+{{- range $path, $bytes := $.Files.Glob (printf "dashboards/%s/**.json" $folder) }}
+{{- $overrides := include "grafana-dashboards.dashboardOverrides" (dict "root" $ "path" $path) | fromJson }}
+{{- if not $overrides.disabled }}
+{{- $name := base $path | trimSuffix ".json" | kebabcase }}
+{{- with get $names $name }}
+{{- fail (printf "%s and %s both render GrafanaDashboard %q; rename one of them" . $path $name) }}
+{{- end }}
+{{- $_ := set $names $name $path }}
+{{- $paths = append $paths $path }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- $paths | toJson }}
+{{- end }}
+
+{{/*
+Overrides from the <dashboard>.values.yaml next to the dashboard at path.
+Expects a dict with root and path. Fails on invalid YAML, unknown keys and
+a disabled value that is not a boolean. Returns a JSON object.
+*/}}
+{{- define "grafana-dashboards.dashboardOverrides" -}}
+{{- $valuesPath := printf "%s.values.yaml" (trimSuffix ".json" .path) }}
+{{- $overrides := .root.Files.Get $valuesPath | fromYaml }}
+{{- if hasKey $overrides "Error" }}
+{{- fail (printf "%s is not valid YAML: %s" $valuesPath $overrides.Error) }}
+{{- end }}
+{{- range $key := keys $overrides | sortAlpha }}
+{{- if not (has $key (list "folder" "tags" "refresh" "instanceSelector" "disabled")) }}
+{{- fail (printf "%s: unknown key %q (expected folder, tags, refresh, instanceSelector or disabled)" $valuesPath $key) }}
+{{- end }}
+{{- end }}
+{{- if and (hasKey $overrides "disabled") (not (kindIs "bool" $overrides.disabled)) }}
+{{- fail (printf "%s: disabled must be true or false, got %q" $valuesPath (toString $overrides.disabled)) }}
+{{- end }}
+{{- $overrides | toJson }}
+{{- end }}
+
+{{/*
+Panel types shipped with Grafana that do not need a plugin, including the
+legacy graph, singlestat and table-old panels that Grafana migrates when
+the dashboard is loaded. Returns a JSON list.
+*/}}
+{{- define "grafana-dashboards.corePanelTypes" -}}
+{{- list "alertlist" "annolist" "barchart" "bargauge" "candlestick" "canvas" "dashlist" "datagrid" "flamegraph" "gauge" "geomap" "gettingstarted" "graph" "heatmap" "histogram" "live" "logs" "news" "nodeGraph" "piechart" "row" "singlestat" "stat" "state-timeline" "status-history" "table" "table-old" "text" "timeseries" "traces" "trend" "welcome" "xychart" | toJson }}
+{{- end }}
+
+{{/*
+Panel types used by a parsed dashboard, including panels nested in
+collapsed rows. Returns a JSON list.
+*/}}
+{{- define "grafana-dashboards.panelTypes" -}}
+{{- $types := list }}
+{{- range $panel := .panels }}
+{{- with $panel.type }}
+{{- $types = append $types . }}
+{{- end }}
+{{- range $nested := $panel.panels }}
+{{- with $nested.type }}
+{{- $types = append $types . }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- $types | uniq | toJson }}
+{{- end }}
+
+{{/*
+Fail when a parsed dashboard uses a panel type that is neither a core
+panel nor listed in plugins, or has threshold errors. Expects a dict with
+root, path and dashboard.
+*/}}
+{{- define "grafana-dashboards.checkDashboard" -}}
+{{- $path := .path }}
+{{- $corePanelTypes := include "grafana-dashboards.corePanelTypes" . | fromJsonArray }}
+{{- $declaredPlugins := list }}
+{{- range .root.Values.plugins }}
+{{- $declaredPlugins = append $declaredPlugins .name }}
+{{- end }}
+{{- range $type := include "grafana-dashboards.panelTypes" .dashboard | fromJsonArray }}
+{{- if not (or (has $type $corePanelTypes) (has $type $declaredPlugins)) }}
+{{- fail (printf "%s uses panel type %q which is not a core panel and is not listed in plugins" $path $type) }}
+{{- end }}
+{{- end }}
+{{- range $error := (include "grafana-dashboards.thresholdFindings" .dashboard | fromJson).errors }}
+{{- fail (printf "%s: %s" $path $error) }}
+{{- end }}
+{{- end }}
+
+{{/*
+Paths of the dashboard JSON files copied for every tenant. Empty without
+tenants; dashboards disabled through their <dashboard>.values.yaml are
+skipped. Returns a JSON list.
+*/}}
+{{- define "grafana-dashboards.tenantDashboardPaths" -}}
+{{- $paths := list }}
+{{- if .Values.tenants }}
+{{- range $file := .Values.tenancy.dashboards }}
+{{- $path := printf "dashboards/%s" $file }}
+{{- if not ($.Files.Get $path) }}
+{{- fail (printf "tenancy.dashboards: %s does not exist" $path) }}
+{{- end }}
+{{- if not (include "grafana-dashboards.dashboardOverrides" (dict "root" $ "path" $path) | fromJson).disabled }}
+{{- $paths = append $paths $path }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- $paths | toJson }}
+{{- end }}
+
+{{/*
+Verify dashboards.lock when it exists. The lock uses the sha256sum format
+and must list every deployed dashboard, including those copied for tenants,
+(and its values file, if any) with a checksum matching the file in the chart.
+*/}}
+{{- define "grafana-dashboards.verifyLock" -}}
+{{- if .Files.Get "dashboards.lock" }}
+{{- $lock := dict }}
+{{- range $line := .Files.Lines "dashboards.lock" }}
+{{- if trim $line }}
+{{- $fields := regexSplit "\\s+" (trim $line) 2 }}
+{{- $path := last $fields | trimPrefix "*" }}
+{{- $_ := set $lock $path (first $fields) }}
+{{- if ne (first $fields) (sha256sum ($.Files.Get $path)) }}
+{{- fail (printf "%s does not match dashboards.lock; review the change and regenerate the lock" $path) }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- $paths := concat (include "grafana-dashboards.dashboardPaths" . | fromJsonArray) (include "grafana-dashboards.tenantDashboardPaths" . | fromJsonArray) }}
+{{- range $path := $paths | uniq }}
+{{- $valuesPath := printf "%s.values.yaml" (trimSuffix ".json" $path) }}
+{{- if not (hasKey $lock $path) }}
+{{- fail (printf "%s is not listed in dashboards.lock; review the change and regenerate the lock" $path) }}
+{{- end }}
+{{- if and ($.Files.Get $valuesPath) (not (hasKey $lock $valuesPath)) }}
+{{- fail (printf "%s is not listed in dashboards.lock; review the change and regenerate the lock" $valuesPath) }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- end }}
+
+{{/*
+Datasources to create: the datasources value plus the Thanos querier
+datasource when openshiftMonitoring is enabled. Returns a JSON list.
+*/}}
+{{- define "grafana-dashboards.datasources" -}}
+{{- $datasources := .Values.datasources | default list }}
+{{- with .Values.openshiftMonitoring }}
+{{- if .enabled }}
+{{- $datasources = append $datasources (dict
+  "name" .datasourceName
+  "uid" .datasourceUid
+  "type" "prometheus"
+  "url" .url
+  "isDefault" .isDefault
+  "jsonData" (dict "timeInterval" "30s")
+  "authSecretRef" (dict "name" (include "grafana-dashboards.serviceAccountTokenSecret" $) "key" "token")
+  "caConfigMapRef" (dict "name" (include "grafana-dashboards.serviceCAConfigMap" $) "key" "service-ca.crt")
+) }}
+{{- end }}
+{{- end }}
+{{- $datasources | toJson }}
+{{- end }}
+
+{{/*
+Name of the Secret holding the token of rbac.serviceAccountName.
+*/}}
+{{- define "grafana-dashboards.serviceAccountTokenSecret" -}}
+{{- printf "%s-token" .Values.rbac.serviceAccountName }}
+{{- end }}
+
+{{/*
+Name of the role and binding created for rbac.serviceAccountName. Cluster
+scoped ones are prefixed with the release namespace so that releases in
+different namespaces do not collide.
+*/}}
+{{- define "grafana-dashboards.rbacName" -}}
+{{- if .Values.rbac.clusterWide }}
+{{- printf "%s-%s" .Release.Namespace .Values.rbac.serviceAccountName }}
+{{- else }}
+{{- .Values.rbac.serviceAccountName }}
+{{- end }}
+{{- end }}
+
+{{/*
+Name of the ConfigMap the OpenShift service CA operator injects its bundle into.
+*/}}
+{{- define "grafana-dashboards.serviceCAConfigMap" -}}
+{{- printf "%s-service-ca" .Release.Name }}
+{{- end }}
+
+{{/*
+Grafana folder title for a dashboard path: the first grafanaFolders entry
+listing the dashboard's folder in dashboardFolders, otherwise grafanaFolder.
+*/}}
+{{- define "grafana-dashboards.folderTitle" -}}
+{{- $path := .path }}
+{{- $title := .root.Values.grafanaFolder }}
+{{- range $folder := reverse (.root.Values.grafanaFolders | default list) }}
+{{- range $dashboardFolder := $folder.dashboardFolders }}
+{{- if hasPrefix (printf "dashboards/%s/" $dashboardFolder) $path }}
+{{- $title = $folder.title }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- $title }}
+{{- end }}
+
+{{/*
+Kubernetes resource name for a free-form name such as a folder title:
+kebab-cased, with every run of characters outside [a-z0-9-] replaced by a
+single dash. Fails when nothing usable is left.
+*/}}
+{{- define "grafana-dashboards.resourceName" -}}
+{{- $name := regexReplaceAll "[^a-z0-9-]+" (kebabcase .) "-" }}
+{{- $name = regexReplaceAll "-{2,}" $name "-" | trunc 253 | trimAll "-" }}
+{{- if not $name }}
+{{- fail (printf "cannot derive a resource name from %q" .) }}
+{{- end }}
+{{- $name }}
+{{- end }}
+
+{{/*
+Name of the GrafanaFolder resource rendered for the grafanaFolders entry
+with the given title, or nothing when the folder is not managed by the
+chart. Expects a dict with root and title.
+*/}}
+{{- define "grafana-dashboards.folderRef" -}}
+{{- $ref := "" }}
+{{- range $folder := .root.Values.grafanaFolders }}
+{{- if and (not $ref) (eq $folder.title $.title) }}
+{{- $ref = include "grafana-dashboards.resourceName" $folder.title }}
+{{- end }}
+{{- end }}
+{{- $ref }}
+{{- end }}
+
+{{/*
+Threshold findings for the stat, gauge and bargauge panels of a parsed
+dashboard, including panels nested in collapsed rows. Errors cover
+threshold steps that are not increasing and gauges whose min is not below
+max; warnings cover percentage units whose absolute thresholds or max use
+the other scale, and thresholds that are neither used for coloring nor
+drawn as gauge threshold markers.
+Returns a JSON object with "errors" and "warnings" lists.
+*/}}
+{{- define "grafana-dashboards.thresholdFindings" -}}
+{{- $errors := list }}
+{{- $warnings := list }}
+{{- $panels := list }}
+{{- range $panel := .panels }}
+{{- $panels = append $panels $panel }}
+{{- range $nested := $panel.panels }}
+{{- $panels = append $panels $nested }}
+{{- end }}
+{{- end }}
+{{- range $panel := $panels }}
+{{- if has $panel.type (list "stat" "gauge" "bargauge") }}
+{{- $defaults := (($panel.fieldConfig | default dict).defaults | default dict) }}
+{{- $steps := (($defaults.thresholds | default dict).steps | default list) }}
+{{- $percentageSteps := eq (($defaults.thresholds | default dict).mode | toString) "percentage" }}
+{{- $title := $panel.title | default (printf "panel %v" $panel.id) }}
+{{- $previous := "" }}
+{{- range $step := $steps }}
+{{- if kindIs "float64" $step.value }}
+{{- if and (kindIs "float64" $previous) (le $step.value $previous) }}
+{{- $errors = append $errors (printf "%q: threshold steps must increase, got %v after %v" $title $step.value $previous) }}
+{{- end }}
+{{- $previous = $step.value }}
+{{- if and (not $percentageSteps) (eq ($defaults.unit | toString) "percentunit") (gt $step.value 1.0) }}
+{{- $warnings = append $warnings (printf "%q: unit is percentunit (0-1) but threshold %v is above 1" $title $step.value) }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- if and (kindIs "float64" $defaults.min) (kindIs "float64" $defaults.max) (ge $defaults.min $defaults.max) }}
+{{- $errors = append $errors (printf "%q: min %v must be below max %v" $title $defaults.min $defaults.max) }}
+{{- end }}
+{{- if and (eq ($defaults.unit | toString) "percent") (kindIs "float64" $defaults.max) (le $defaults.max 1.0) }}
+{{- $warnings = append $warnings (printf "%q: unit is percent (0-100) but max is %v" $title $defaults.max) }}
+{{- end }}
+{{- if and (eq ($defaults.unit | toString) "percentunit") (kindIs "float64" $defaults.max) (gt $defaults.max 1.0) }}
+{{- $warnings = append $warnings (printf "%q: unit is percentunit (0-1) but max is %v" $title $defaults.max) }}
+{{- end }}
+{{- $colorMode := (($defaults.color | default dict).mode | default "thresholds") }}
+{{- $options := $panel.options | default dict }}
+{{- $markers := and (eq $panel.type "gauge") (ne (toString $options.showThresholdMarkers) "false") }}
+{{- if and (gt (len $steps) 1) (ne $colorMode "thresholds") (not $markers) }}
+{{- $warnings = append $warnings (printf "%q: thresholds are defined but color mode is %q so they are never shown" $title $colorMode) }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- dict "errors" $errors "warnings" $warnings | toJson }}
+{{- end }}
+
+{{/*
+GrafanaDashboard resource for dashboard JSON taken from path. Expects a
+dict with root, path, name, json, folder and instanceSelector. Folders
+managed through grafanaFolders are referenced by their GrafanaFolder
+resource. Fails when the JSON exceeds dashboardSizeLimit.
+*/}}
+{{- define "grafana-dashboards.dashboardResource" -}}
+{{- $root := .root }}
+{{- if and $root.Values.dashboardSizeLimit (gt (len .json) (int $root.Values.dashboardSizeLimit)) }}
+{{- fail (printf "%s is %d bytes which exceeds dashboardSizeLimit (%d bytes)" .path (len .json) (int $root.Values.dashboardSizeLimit)) }}
+{{- end }}
+---
+apiVersion: grafana.integreatly.org/v1beta1
+kind: GrafanaDashboard
+metadata:
+  name: {{ .name }}
+  {{- with $root.Values.commonLabels }}
+  labels:
+    {{- toYaml . | nindent 4 }}
+  {{- end }}
+  {{- with $root.Values.commonAnnotations }}
+  annotations:
+    {{- toYaml . | nindent 4 }}
+  {{- end }}
+spec:
+  name: {{ .name }}
+  instanceSelector:
+    {{- toYaml .instanceSelector | nindent 4 }}
+  json: |
+    {{- .json | nindent 4 }}
+  {{- with include "grafana-dashboards.folderRef" (dict "root" $root "title" .folder) }}
+  folderRef: {{ . }}
+  {{- else }}
+  folder: {{ .folder | quote }}
+  {{- end }}
+  {{- if $root.Values.plugins }}
+  plugins:
+    {{- toYaml $root.Values.plugins | nindent 4 }}
+  {{- end }}
+{{- end }}
+
+{{/*
+Point the datasource stored under .key in .object at the .uid Prometheus
+datasource. Prometheus references, datasource variables and unset
+datasources (with .replaceUnset) are rewritten; built-in datasources,
+other datasource types and legacy references by name are left alone.
+Mutates .object in place.
+*/}}
+{{- define "grafana-dashboards.rewriteDatasource" -}}
+{{- $current := get .object .key }}
+{{- $rewrite := false }}
+{{- if kindIs "map" $current }}
+{{- $rewrite = or (eq (toString $current.type) "prometheus") (and (not $current.type) (hasPrefix "$" (toString $current.uid))) }}
+{{- else if kindIs "string" $current }}
+{{- $rewrite = hasPrefix "$" $current }}
+{{- else }}
+{{- $rewrite = .replaceUnset }}
+{{- end }}
+{{- if $rewrite }}
+{{- $_ := set .object .key (dict "type" "prometheus" "uid" .uid) }}
+{{- end }}
+{{- end }}
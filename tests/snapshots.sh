@@ -0,0 +1,90 @@
+#!/bin/sh
+# Render the chart for every values file in tests/values and compare the
+# output with its golden file in tests/__snapshots__. Values files in
+# tests/failures must fail to render with the message in their
+# "# expect:" line.
+#
+# A values file with a "# fixture: <name>..." line is rendered against a
+# temporary copy of the chart with the files of tests/fixtures/<name>
+# copied on top, in the order listed, to exercise dashboards, rules and
+# locks that the chart does not ship.
+#
+# Usage: tests/snapshots.sh [--update]
+#
+# --update rewrites the golden files from the current templates; review
+# the resulting diff before committing it. Set HELM to use another helm
+# binary.
+set -eu
+
+cd "$(dirname "$0")/.."
+helm=${HELM:-helm}
+update=false
+if [ "${1:-}" = "--update" ]; then
+  update=true
+fi
+
+status=0
+work=$(mktemp -d)
+trap 'rm -rf "$work"' EXIT
+out="$work/out"
+
+# render VALUES: run helm template for VALUES, on a copy of the chart with
+# its fixtures when it names any.
+render() {
+  chart=.
+  fixtures=$(sed -n 's/^# fixture: //p' "$1")
+  if [ -n "$fixtures" ]; then
+    chart="$work/chart"
+    rm -rf "$chart"
+    mkdir "$chart"
+    for file in * .helmignore; do
+      [ "$file" = tests ] || cp -R "$file" "$chart/"
+    done
+    for fixture in $fixtures; do
+      if [ ! -d "tests/fixtures/$fixture" ]; then
+        echo "tests/fixtures/$fixture does not exist" >&2
+        return 1
+      fi
+      cp -R "tests/fixtures/$fixture/." "$chart/"
+    done
+  fi
+  $helm template grafana-dashboards "$chart" --namespace monitoring -f "$1"
+}
+
+for values in tests/values/*.yaml; do
+  name=$(basename "$values")
+  snapshot="tests/__snapshots__/$name"
+  if ! render "$values" > "$out"; then
+    echo "FAIL $name: rendering failed"
+    status=1
+    continue
+  fi
+  if $update; then
+    cp "$out" "$snapshot"
+    echo "updated $snapshot"
+  elif [ ! -f "$snapshot" ]; then
+    echo "FAIL $name: $snapshot is missing; run with --update to create it"
+    status=1
+  elif ! diff -u "$snapshot" "$out"; then
+    echo "FAIL $name: output differs from $snapshot; run with --update to accept it"
+    status=1
+  fi
+done
+
+for values in tests/failures/*.yaml; do
+  name=$(basename "$values")
+  expect=$(sed -n 's/^# expect: //p' "$values")
+  if render "$values" > /dev/null 2> "$out"; then
+    echo "FAIL $name: rendered without an error"
+    status=1
+  elif ! grep -qF -- "$expect" "$out"; then
+    echo "FAIL $name: expected an error containing: $expect"
+    cat "$out"
+    status=1
+  fi
+done
+
+if [ "$status" -eq 0 ]; then
+  echo "all snapshots match"
+fi
+exit "$status"